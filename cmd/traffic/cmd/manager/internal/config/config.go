@@ -4,11 +4,16 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/k8sapi/pkg/k8sapi"
@@ -18,6 +23,11 @@ const (
 	clientConfigFileName         = "client.yaml"
 	trafficManagerConfigFileName = "traffic-manager.yaml"
 	cfgConfigMapName             = "traffic-manager"
+
+	// resyncPeriod controls how often the informer relists its source even in the
+	// absence of watch events, so that a missed Delete can never leave this process with
+	// a stale view of the config forever.
+	resyncPeriod = 10 * time.Minute
 )
 
 type WatcherCallback func(watch.EventType, runtime.Object) error
@@ -26,96 +36,179 @@ type Watcher interface {
 	Run(ctx context.Context) error
 	GetClientConfigYaml() []byte
 	GetTrafficManagerConfigYaml() []byte
+
+	// GetClientConfig returns the last successfully parsed client.yaml, or nil if none
+	// has been seen yet or the source doesn't carry that key.
+	GetClientConfig(ctx context.Context) (*ClientConfig, error)
+
+	// GetTrafficManagerConfig returns the last successfully parsed traffic-manager.yaml.
+	GetTrafficManagerConfig(ctx context.Context) (*TrafficManagerConfig, error)
+
+	// LastError returns the error from the most recent failed parse of either config,
+	// or nil if the last parse of both succeeded.
+	LastError() error
+
+	// Subscribe returns a channel of ConfigEvents for the given kind. The channel is
+	// closed once Run returns.
+	Subscribe(kind ConfigKind) <-chan ConfigEvent
+
+	// AddEventHandler registers cb to be called with each informer event on the primary
+	// config object (the traffic-manager ConfigMap or TelepresenceConfig). Changes from
+	// labeled ConfigMaps or the overlay directory do not call it; Subscribe sees those.
+	// The returned cancel function removes the registration.
+	AddEventHandler(cb WatcherCallback) (cancel func())
 }
 
-type config struct {
-	sync.RWMutex
+// configMapWatcher is the original Watcher implementation: it sources client.yaml and
+// traffic-manager.yaml from the traffic-manager ConfigMap, optionally merged with
+// labeled sibling ConfigMaps and a local file overlay. See NewWatcher.
+type configMapWatcher struct {
+	watcherState
 	namespace string
 
-	clientYAML         []byte
-	trafficManagerYAML []byte
+	informer cache.SharedIndexInformer
+
+	// Additional sources, merged key-wise on top of the primary ConfigMap. See
+	// WithLabeledConfigMaps and WithOverlayDir.
+	watchLabeledConfigMaps bool
+	overlayDir             string
+
+	sourcesMu   sync.Mutex
+	primaryData map[string]string
+	labeledData map[string]map[string]string
+	overlayData map[string]string
 }
 
-func NewWatcher(namespace string) Watcher {
-	return &config{
-		namespace: namespace,
+// NewWatcher returns a Watcher for namespace. If the TelepresenceConfig CRD
+// (getambassador.io/v1) is installed in the cluster, its single namespaced instance is
+// used as the source of truth and restConfig is used to build the cache that watches it;
+// otherwise this falls back to the traffic-manager ConfigMap and restConfig is unused.
+// Either way, callers see the same Watcher interface.
+func NewWatcher(ctx context.Context, namespace string, restConfig *rest.Config, opts ...WatcherOption) Watcher {
+	if crdInstalled(ctx) {
+		return newCRDWatcher(ctx, namespace, restConfig, opts...)
 	}
+	return newConfigMapWatcher(namespace, opts...)
 }
 
-func (c *config) Run(ctx context.Context) error {
-	dlog.Infof(ctx, "Started watcher for ConfigMap %s", cfgConfigMapName)
-	defer dlog.Infof(ctx, "Ended watcher for ConfigMap %s", cfgConfigMapName)
+func newConfigMapWatcher(namespace string, opts ...WatcherOption) Watcher {
+	c := &configMapWatcher{
+		watcherState: newWatcherState(),
+		namespace:    namespace,
+		labeledData:  make(map[string]map[string]string),
+	}
+	c.eventRef = c.configMapRef
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
 
-	// The Watch will perform a http GET call to the kubernetes API server, and that connection will not remain open forever
-	// so when it closes, the watch must start over. This goes on until the context is cancelled.
-	api := k8sapi.GetK8sInterface(ctx).CoreV1()
-	for ctx.Err() == nil {
-		w, err := api.ConfigMaps(c.namespace).Watch(ctx, meta.SingleObject(meta.ObjectMeta{Name: cfgConfigMapName}))
-		if err != nil {
-			return fmt.Errorf("unable to create configmap watcher: %v", err)
-		}
-		if !c.configMapEventHandler(ctx, w.ResultChan()) {
-			return nil
-		}
+func (c *configMapWatcher) configMapRef() *core.ObjectReference {
+	return &core.ObjectReference{
+		Kind:      "ConfigMap",
+		Namespace: c.namespace,
+		Name:      cfgConfigMapName,
 	}
-	return nil
 }
 
-func (c *config) configMapEventHandler(ctx context.Context, evCh <-chan watch.Event) bool {
-	for {
-		select {
-		case <-ctx.Done():
-			return false
-		case event, ok := <-evCh:
-			if !ok {
-				return true // restart watcher
-			}
-			switch event.Type {
-			case watch.Deleted:
-				if m, ok := event.Object.(*core.ConfigMap); ok {
-					dlog.Debugf(ctx, "%s %s", event.Type, m.Name)
-					c.refreshFile(ctx, nil)
-				}
-			case watch.Added, watch.Modified:
-				if m, ok := event.Object.(*core.ConfigMap); ok {
-					dlog.Debugf(ctx, "%s %s", event.Type, m.Name)
-					c.refreshFile(ctx, m.Data)
-				}
+// Run starts the ConfigMap informer and waits for its cache to perform its initial sync
+// before it starts the labeled-ConfigMap and overlay sources, returning an error if that
+// sync fails. It then blocks until ctx is cancelled. GetClientConfigYaml and
+// GetTrafficManagerConfigYaml do not wait for this sync: until the first observation of
+// the ConfigMap has been processed, they return nil.
+func (c *configMapWatcher) Run(ctx context.Context) error {
+	dlog.Infof(ctx, "Started watcher for ConfigMap %s", cfgConfigMapName)
+	defer dlog.Infof(ctx, "Ended watcher for ConfigMap %s", cfgConfigMapName)
+	defer c.closeSubscribers()
+
+	c.recorder = newEventRecorder(ctx, c.namespace)
+
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		k8sapi.GetK8sInterface(ctx),
+		resyncPeriod,
+		informers.WithNamespace(c.namespace),
+		informers.WithTweakListOptions(func(lo *meta.ListOptions) {
+			lo.FieldSelector = fields.OneTermEqualSelector("metadata.name", cfgConfigMapName).String()
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	c.informer = informer
+
+	if err := informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		dlog.Warnf(ctx, "configmap watch for %s disconnected, reconnecting: %v", cfgConfigMapName, err)
+		watchReconnectsTotal.Inc()
+	}); err != nil {
+		return fmt.Errorf("unable to set configmap watch error handler: %v", err)
+	}
+
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.handlePrimaryConfigMap(ctx, watch.Added, obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			c.handlePrimaryConfigMap(ctx, watch.Modified, obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if m, ok := configMapFromEventObject(obj); ok {
+				c.setPrimaryData(ctx, nil)
+				c.notify(ctx, watch.Deleted, m)
 			}
-		}
+		},
+	}); err != nil {
+		return fmt.Errorf("unable to add configmap event handler: %v", err)
 	}
-}
 
-func (c *config) refreshFile(ctx context.Context, data map[string]string) {
-	c.Lock()
-	if yml, ok := data[clientConfigFileName]; ok {
-		c.clientYAML = []byte(yml)
-		dlog.Debugf(ctx, "Refreshed client config: %s", yml)
-	} else {
-		c.clientYAML = nil
-		dlog.Debugf(ctx, "Cleared client config")
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("unable to sync configmap informer cache for %s", cfgConfigMapName)
 	}
 
-	if yml, ok := data[trafficManagerConfigFileName]; ok {
-		c.trafficManagerYAML = []byte(yml)
-		dlog.Debugf(ctx, "Refreshed traffic-manager config: %s", yml)
-	} else {
-		c.trafficManagerYAML = nil
-		dlog.Debugf(ctx, "Cleared traffic-manager config")
+	if c.watchLabeledConfigMaps {
+		if err := c.runLabeledConfigMaps(ctx); err != nil {
+			return fmt.Errorf("unable to watch labeled ConfigMaps: %v", err)
+		}
+	}
+	if c.overlayDir != "" {
+		go func() {
+			if err := c.runOverlay(ctx); err != nil {
+				dlog.Errorf(ctx, "config overlay watcher for %s stopped: %v", c.overlayDir, err)
+			}
+		}()
 	}
-	c.Unlock()
+
+	<-ctx.Done()
+	return nil
+}
+
+func (c *configMapWatcher) handlePrimaryConfigMap(ctx context.Context, evType watch.EventType, obj interface{}) {
+	m, ok := configMapFromEventObject(obj)
+	if !ok {
+		return
+	}
+	dlog.Debugf(ctx, "%s %s", evType, m.Name)
+	c.setPrimaryData(ctx, m.Data)
+	c.notify(ctx, evType, m)
 }
 
-func (c *config) GetClientConfigYaml() (ret []byte) {
-	c.RLock()
-	ret = c.clientYAML
-	c.RUnlock()
-	return
+func (c *configMapWatcher) setPrimaryData(ctx context.Context, data map[string]string) {
+	c.sourcesMu.Lock()
+	defer c.sourcesMu.Unlock()
+	c.primaryData = data
+	c.recomputeMergedLocked(ctx)
 }
 
-func (c *config) GetTrafficManagerConfigYaml() (ret []byte) {
-	c.RLock()
-	ret = c.trafficManagerYAML
-	c.RUnlock()
-	return
+// configMapFromEventObject unwraps the *core.ConfigMap delivered by an informer event,
+// including the tombstone wrapper client-go uses when a Delete is observed for an object
+// whose last known state was already evicted from the cache.
+func configMapFromEventObject(obj interface{}) (*core.ConfigMap, bool) {
+	if m, ok := obj.(*core.ConfigMap); ok {
+		return m, true
+	}
+	if tomb, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		if m, ok := tomb.Obj.(*core.ConfigMap); ok {
+			return m, true
+		}
+	}
+	return nil, false
 }
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/datawire/k8sapi/pkg/k8sapi"
+)
+
+const testNamespace = "ambassador"
+
+// waitFor polls cond until it returns true or the deadline passes, failing the test on
+// timeout so a stuck Run doesn't hang the suite forever.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
+
+// TestConfigMapWatcherRun drives Run against a fake clientset to cover the cache-sync
+// guarantee described in its doc comment, along with add/update/delete handling of the
+// primary ConfigMap.
+func TestConfigMapWatcherRun(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&core.ConfigMap{
+		ObjectMeta: meta.ObjectMeta{Name: cfgConfigMapName, Namespace: testNamespace},
+		Data:       map[string]string{trafficManagerConfigFileName: "agentImage: tel2:1\n"},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = k8sapi.WithK8sInterface(ctx, clientset)
+
+	w := newConfigMapWatcher(testNamespace).(*configMapWatcher)
+
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+
+	// Wait for Run's own cache-sync gate (rather than just the side effect of the
+	// primary ConfigMap being visible) so a cancel below can never race ahead of
+	// WaitForCacheSync and make Run spuriously fail with a sync error.
+	waitFor(t, func() bool { return w.informer != nil && w.informer.HasSynced() })
+
+	// cache.WaitForCacheSync itself only re-checks informer.HasSynced() every 100ms
+	// (client-go's syncedPollPeriod), so Run can still be inside that call a moment
+	// after HasSynced() first flips true. Give it a beat so a cancel below can't race
+	// ahead of Run's own WaitForCacheSync and make it return a spurious sync error.
+	time.Sleep(150 * time.Millisecond)
+
+	// Once Run has synced, the ConfigMap that already existed before Run was called
+	// must already be visible without any further event needed.
+	if got := string(w.GetTrafficManagerConfigYaml()); got != "agentImage: tel2:1\n" {
+		t.Fatalf("after sync: got %q, want the pre-existing ConfigMap's data", got)
+	}
+
+	if _, err := clientset.CoreV1().ConfigMaps(testNamespace).Update(ctx, &core.ConfigMap{
+		ObjectMeta: meta.ObjectMeta{Name: cfgConfigMapName, Namespace: testNamespace},
+		Data:       map[string]string{trafficManagerConfigFileName: "agentImage: tel2:2\n"},
+	}, meta.UpdateOptions{}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	waitFor(t, func() bool { return string(w.GetTrafficManagerConfigYaml()) == "agentImage: tel2:2\n" })
+
+	if err := clientset.CoreV1().ConfigMaps(testNamespace).Delete(ctx, cfgConfigMapName, meta.DeleteOptions{}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	waitFor(t, func() bool { return w.GetTrafficManagerConfigYaml() == nil })
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after ctx was cancelled")
+	}
+}
+
+// TestConfigMapFromEventObjectUnwrapsTombstone covers the DeletedFinalStateUnknown path
+// Run's DeleteFunc relies on when a Delete is observed for an object whose last known
+// state was already evicted from the informer's cache.
+func TestConfigMapFromEventObjectUnwrapsTombstone(t *testing.T) {
+	m := &core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: cfgConfigMapName, Namespace: testNamespace}}
+
+	got, ok := configMapFromEventObject(m)
+	if !ok || got != m {
+		t.Fatalf("plain object: got %v, %v", got, ok)
+	}
+
+	tomb := cache.DeletedFinalStateUnknown{Key: testNamespace + "/" + cfgConfigMapName, Obj: m}
+	got, ok = configMapFromEventObject(tomb)
+	if !ok || got != m {
+		t.Fatalf("tombstone: got %v, %v", got, ok)
+	}
+
+	if _, ok := configMapFromEventObject("not a configmap"); ok {
+		t.Fatal("expected ok=false for an object of the wrong type")
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestTelepresenceConfigToYAML(t *testing.T) {
+	t.Run("both sections present", func(t *testing.T) {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"client": map[string]interface{}{
+					"logLevel": "debug",
+				},
+				"trafficManager": map[string]interface{}{
+					"agentImage": "tel2:2.18.0",
+				},
+			},
+		}}
+		data := telepresenceConfigToYAML(u)
+
+		if _, err := parseClientConfig([]byte(data[clientConfigFileName])); err != nil {
+			t.Fatalf("client.yaml did not round-trip through parseClientConfig: %v", err)
+		}
+		if _, err := parseTrafficManagerConfig([]byte(data[trafficManagerConfigFileName])); err != nil {
+			t.Fatalf("traffic-manager.yaml did not round-trip through parseTrafficManagerConfig: %v", err)
+		}
+	})
+
+	t.Run("section absent is omitted, not emitted empty", func(t *testing.T) {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"client": map[string]interface{}{
+					"logLevel": "info",
+				},
+			},
+		}}
+		data := telepresenceConfigToYAML(u)
+
+		if _, ok := data[clientConfigFileName]; !ok {
+			t.Fatalf("expected %s to be present", clientConfigFileName)
+		}
+		if _, ok := data[trafficManagerConfigFileName]; ok {
+			t.Fatalf("expected %s to be absent, got %q", trafficManagerConfigFileName, data[trafficManagerConfigFileName])
+		}
+	})
+
+	t.Run("no spec at all yields no data", func(t *testing.T) {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		data := telepresenceConfigToYAML(u)
+		if len(data) != 0 {
+			t.Fatalf("expected no data, got %#v", data)
+		}
+	})
+}
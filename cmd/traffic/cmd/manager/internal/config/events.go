@@ -0,0 +1,23 @@
+package config
+
+import (
+	"context"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcore "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/datawire/k8sapi/pkg/k8sapi"
+)
+
+// newEventRecorder builds an EventRecorder that the watchers use to annotate their config
+// object (the traffic-manager ConfigMap, or the TelepresenceConfig when the CRD is in use)
+// with Events, so that an operator running `kubectl describe` on it sees immediately when
+// their edit was accepted or rejected instead of having to dig through traffic-manager logs.
+func newEventRecorder(ctx context.Context, namespace string) record.EventRecorder {
+	ifc := k8sapi.GetK8sInterface(ctx)
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcore.EventSinkImpl{Interface: ifc.CoreV1().Events(namespace)})
+	return broadcaster.NewRecorder(scheme.Scheme, core.EventSource{Component: "traffic-manager-config"})
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics give operators an observable feedback loop when they edit the config, alongside
+// the error logs and Kubernetes Events that refreshFile records.
+var (
+	reloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telepresence_config_reloads_total",
+		Help: "Number of times a config of the given kind changed and was successfully parsed.",
+	}, []string{"kind"})
+
+	parseErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telepresence_config_parse_errors_total",
+		Help: "Number of times a config of the given kind failed to parse.",
+	}, []string{"kind"})
+
+	lastReloadTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "telepresence_config_last_reload_timestamp_seconds",
+		Help: "Unix timestamp of the last successful parse of a changed config of the given kind.",
+	}, []string{"kind"})
+
+	watchReconnectsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "telepresence_config_watch_reconnects_total",
+		Help: "Number of times the underlying config watch had to reconnect to the API server.",
+	})
+)
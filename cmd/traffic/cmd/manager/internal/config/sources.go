@@ -0,0 +1,193 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/fsnotify/fsnotify"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/k8sapi/pkg/k8sapi"
+)
+
+// configMapLabelSelector selects additional ConfigMaps, beyond cfgConfigMapName, whose
+// client.yaml / traffic-manager.yaml keys are merged into the watcher's view. This lets
+// an operator split a large config across several ConfigMaps instead of one.
+const configMapLabelSelector = "telepresence.io/config=true"
+
+// WatcherOption configures optional config sources on top of the primary ConfigMap.
+type WatcherOption func(*configMapWatcher)
+
+// WithLabeledConfigMaps makes the Watcher also merge in any ConfigMap in the same
+// namespace carrying the telepresence.io/config=true label, in name order, with each
+// one overriding keys set by ConfigMaps earlier in that order.
+func WithLabeledConfigMaps() WatcherOption {
+	return func(c *configMapWatcher) {
+		c.watchLabeledConfigMaps = true
+	}
+}
+
+// WithOverlayDir makes the Watcher overlay client.yaml / traffic-manager.yaml files read
+// from dir on top of whatever was assembled from the cluster, so a developer can iterate
+// on config locally without touching the cluster. Files in dir always win.
+func WithOverlayDir(dir string) WatcherOption {
+	return func(c *configMapWatcher) {
+		c.overlayDir = dir
+	}
+}
+
+// mergeSources combines sources in order, per well-known filename, so that a later
+// source's client.yaml or traffic-manager.yaml entirely replaces an earlier source's
+// entry for that same filename; sources that don't carry a given filename leave
+// whatever an earlier source contributed untouched.
+func mergeSources(sources ...map[string]string) map[string]string {
+	merged := make(map[string]string, 2)
+	for _, src := range sources {
+		for _, file := range []string{clientConfigFileName, trafficManagerConfigFileName} {
+			if v, ok := src[file]; ok {
+				merged[file] = v
+			}
+		}
+	}
+	return merged
+}
+
+// recomputeMergedLocked rebuilds the merged view from the primary ConfigMap, any labeled
+// ConfigMaps (sorted by name for determinism), and the file overlay, then feeds it
+// through refreshFile. Deleting any one source correctly falls back to what the
+// remaining sources provide, since the merge always starts from scratch.
+//
+// Callers must hold c.sourcesMu for the whole mutate-then-recompute sequence: the primary
+// ConfigMap informer, the labeled-ConfigMaps informer, and the overlay watcher each run on
+// their own goroutine, and snapshotting the sources without holding the lock across the
+// refreshFile call would let two concurrent source changes race refreshFile, letting the
+// older change land last and leave the watcher on a stale merged view.
+func (c *configMapWatcher) recomputeMergedLocked(ctx context.Context) {
+	sources := make([]map[string]string, 0, len(c.labeledData)+2)
+	sources = append(sources, c.primaryData)
+
+	names := make([]string, 0, len(c.labeledData))
+	for name := range c.labeledData {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sources = append(sources, c.labeledData[name])
+	}
+	sources = append(sources, c.overlayData)
+
+	c.refreshFile(ctx, mergeSources(sources...))
+}
+
+func (c *configMapWatcher) runLabeledConfigMaps(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		k8sapi.GetK8sInterface(ctx),
+		resyncPeriod,
+		informers.WithNamespace(c.namespace),
+		informers.WithTweakListOptions(func(lo *meta.ListOptions) {
+			lo.LabelSelector = configMapLabelSelector
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.setLabeledConfigMap(ctx, obj) },
+		UpdateFunc: func(_, obj interface{}) { c.setLabeledConfigMap(ctx, obj) },
+		DeleteFunc: func(obj interface{}) { c.deleteLabeledConfigMap(ctx, obj) },
+	})
+	if err != nil {
+		return err
+	}
+
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("unable to sync labeled configmap informer cache for selector %q", configMapLabelSelector)
+	}
+	return nil
+}
+
+func (c *configMapWatcher) setLabeledConfigMap(ctx context.Context, obj interface{}) {
+	m, ok := configMapFromEventObject(obj)
+	if !ok || m.Name == cfgConfigMapName {
+		return
+	}
+	c.sourcesMu.Lock()
+	defer c.sourcesMu.Unlock()
+	c.labeledData[m.Name] = m.Data
+	dlog.Debugf(ctx, "Merged labeled ConfigMap %s", m.Name)
+	c.recomputeMergedLocked(ctx)
+}
+
+func (c *configMapWatcher) deleteLabeledConfigMap(ctx context.Context, obj interface{}) {
+	m, ok := configMapFromEventObject(obj)
+	if !ok {
+		return
+	}
+	c.sourcesMu.Lock()
+	defer c.sourcesMu.Unlock()
+	delete(c.labeledData, m.Name)
+	dlog.Debugf(ctx, "Dropped labeled ConfigMap %s", m.Name)
+	c.recomputeMergedLocked(ctx)
+}
+
+// runOverlay watches c.overlayDir with fsnotify and re-reads client.yaml /
+// traffic-manager.yaml from it whenever either changes, overlaying them on top of the
+// cluster-sourced config. It blocks until ctx is cancelled.
+func (c *configMapWatcher) runOverlay(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(c.overlayDir); err != nil {
+		return err
+	}
+
+	c.loadOverlay(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			base := filepath.Base(ev.Name)
+			if base == clientConfigFileName || base == trafficManagerConfigFileName {
+				c.loadOverlay(ctx)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			dlog.Errorf(ctx, "overlay watcher error: %v", err)
+		}
+	}
+}
+
+func (c *configMapWatcher) loadOverlay(ctx context.Context) {
+	data := make(map[string]string, 2)
+	for _, file := range []string{clientConfigFileName, trafficManagerConfigFileName} {
+		b, err := os.ReadFile(filepath.Join(c.overlayDir, file))
+		switch {
+		case err == nil:
+			data[file] = string(b)
+		case os.IsNotExist(err):
+		default:
+			dlog.Errorf(ctx, "unable to read overlay file %s: %v", file, err)
+		}
+	}
+	c.sourcesMu.Lock()
+	defer c.sourcesMu.Unlock()
+	c.overlayData = data
+	dlog.Debugf(ctx, "Reloaded config overlay from %s", c.overlayDir)
+	c.recomputeMergedLocked(ctx)
+}
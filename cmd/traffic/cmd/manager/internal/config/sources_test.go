@@ -0,0 +1,84 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeSources(t *testing.T) {
+	primary := map[string]string{
+		clientConfigFileName:         "logLevel: info\n",
+		trafficManagerConfigFileName: "agentImage: tel2:1\n",
+	}
+	labeled := map[string]string{
+		clientConfigFileName: "logLevel: debug\n",
+	}
+	overlay := map[string]string{
+		trafficManagerConfigFileName: "agentImage: tel2:2\n",
+	}
+
+	tests := []struct {
+		name    string
+		sources []map[string]string
+		want    map[string]string
+	}{
+		{
+			name:    "no sources",
+			sources: nil,
+			want:    map[string]string{},
+		},
+		{
+			name:    "single source passes through",
+			sources: []map[string]string{primary},
+			want:    primary,
+		},
+		{
+			name:    "later source overrides earlier, file by file",
+			sources: []map[string]string{primary, labeled, overlay},
+			want: map[string]string{
+				clientConfigFileName:         "logLevel: debug\n",
+				trafficManagerConfigFileName: "agentImage: tel2:2\n",
+			},
+		},
+		{
+			name:    "source missing a file leaves earlier value untouched",
+			sources: []map[string]string{primary, labeled},
+			want: map[string]string{
+				clientConfigFileName:         "logLevel: debug\n",
+				trafficManagerConfigFileName: "agentImage: tel2:1\n",
+			},
+		},
+		{
+			name:    "nil source is skipped like an empty one",
+			sources: []map[string]string{primary, nil},
+			want:    primary,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeSources(tt.sources...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("mergeSources() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRecomputeMergedFallsBackOnDeletion mirrors what recomputeMergedLocked does when a labeled
+// ConfigMap is deleted: the merge is rebuilt from scratch from whatever sources remain, so
+// the view should fall back to what an earlier source contributed rather than keeping the
+// deleted source's values around.
+func TestRecomputeMergedFallsBackOnDeletion(t *testing.T) {
+	primary := map[string]string{clientConfigFileName: "logLevel: info\n"}
+	labeled := map[string]string{clientConfigFileName: "logLevel: debug\n"}
+
+	before := mergeSources(primary, labeled)
+	if before[clientConfigFileName] != "logLevel: debug\n" {
+		t.Fatalf("before deletion: got %q, want labeled override", before[clientConfigFileName])
+	}
+
+	after := mergeSources(primary)
+	if after[clientConfigFileName] != "logLevel: info\n" {
+		t.Fatalf("after deletion: got %q, want fallback to primary", after[clientConfigFileName])
+	}
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// watcherState holds everything that's common to both Watcher implementations: the raw
+// and parsed config, subscriber bookkeeping, and event recording. Embedding it lets
+// configMapWatcher and crdWatcher share one implementation of the read side of the
+// Watcher interface while each owns its own way of discovering raw config data.
+type watcherState struct {
+	sync.RWMutex
+
+	clientYAML         []byte
+	trafficManagerYAML []byte
+
+	clientConfig         *ClientConfig
+	trafficManagerConfig *TrafficManagerConfig
+	lastErr              error
+
+	recorder record.EventRecorder
+	eventRef func() *core.ObjectReference
+
+	handlersMu sync.Mutex
+	handlers   map[int]WatcherCallback
+	nextID     int
+
+	subsMu sync.Mutex
+	subs   map[ConfigKind][]chan ConfigEvent
+}
+
+func newWatcherState() watcherState {
+	return watcherState{
+		handlers: make(map[int]WatcherCallback),
+		subs:     make(map[ConfigKind][]chan ConfigEvent),
+	}
+}
+
+func (s *watcherState) GetClientConfig(context.Context) (*ClientConfig, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.clientConfig, nil
+}
+
+func (s *watcherState) GetTrafficManagerConfig(context.Context) (*TrafficManagerConfig, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.trafficManagerConfig, nil
+}
+
+func (s *watcherState) LastError() error {
+	s.RLock()
+	defer s.RUnlock()
+	return s.lastErr
+}
+
+func (s *watcherState) GetClientConfigYaml() (ret []byte) {
+	s.RLock()
+	ret = s.clientYAML
+	s.RUnlock()
+	return
+}
+
+func (s *watcherState) GetTrafficManagerConfigYaml() (ret []byte) {
+	s.RLock()
+	ret = s.trafficManagerYAML
+	s.RUnlock()
+	return
+}
+
+func (s *watcherState) Subscribe(kind ConfigKind) <-chan ConfigEvent {
+	ch := make(chan ConfigEvent, 1)
+	s.subsMu.Lock()
+	s.subs[kind] = append(s.subs[kind], ch)
+	s.subsMu.Unlock()
+	return ch
+}
+
+func (s *watcherState) publish(kind ConfigKind, ev ConfigEvent) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for _, ch := range s.subs[kind] {
+		select {
+		case ch <- ev:
+		default:
+			// Slow subscriber; drop rather than block the watcher on it.
+		}
+	}
+}
+
+func (s *watcherState) closeSubscribers() {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for _, chs := range s.subs {
+		for _, ch := range chs {
+			close(ch)
+		}
+	}
+	s.subs = make(map[ConfigKind][]chan ConfigEvent)
+}
+
+func (s *watcherState) AddEventHandler(cb WatcherCallback) (cancel func()) {
+	s.handlersMu.Lock()
+	id := s.nextID
+	s.nextID++
+	s.handlers[id] = cb
+	s.handlersMu.Unlock()
+
+	return func() {
+		s.handlersMu.Lock()
+		delete(s.handlers, id)
+		s.handlersMu.Unlock()
+	}
+}
+
+func (s *watcherState) notify(ctx context.Context, evType watch.EventType, obj runtime.Object) {
+	s.handlersMu.Lock()
+	cbs := make([]WatcherCallback, 0, len(s.handlers))
+	for _, cb := range s.handlers {
+		cbs = append(cbs, cb)
+	}
+	s.handlersMu.Unlock()
+	for _, cb := range cbs {
+		if err := cb(evType, obj); err != nil {
+			dlog.Errorf(ctx, "config watcher callback failed: %v", err)
+		}
+	}
+}
+
+// refreshFile parses data's client.yaml / traffic-manager.yaml entries, updates the
+// cached raw and parsed config, records a Kubernetes Event on a parse failure, and
+// publishes a ConfigEvent to any subscribers of either kind.
+func (s *watcherState) refreshFile(ctx context.Context, data map[string]string) {
+	s.RLock()
+	oldClientYAML := s.clientYAML
+	oldTrafficManagerYAML := s.trafficManagerYAML
+	s.RUnlock()
+
+	yml, hasClient := data[clientConfigFileName]
+	var clientYAML []byte
+	if hasClient {
+		clientYAML = []byte(yml)
+	}
+	clientCfg, clientErr := parseClientConfig(clientYAML)
+	if clientErr != nil {
+		dlog.Errorf(ctx, "invalid client config: %v", clientErr)
+		s.recordParseError(clientConfigFileName, clientErr)
+		parseErrorsTotal.WithLabelValues(ClientConfigKind.String()).Inc()
+	} else {
+		if hasClient {
+			dlog.Debugf(ctx, "Refreshed client config: %s", yml)
+		} else {
+			dlog.Debugf(ctx, "Cleared client config")
+		}
+		// Informer resyncs redeliver the same data on a timer; only the traffic-manager
+		// Event and reload metrics actually changing is interesting, not a relist.
+		if !bytes.Equal(clientYAML, oldClientYAML) {
+			s.recordReload(ClientConfigKind)
+		}
+	}
+
+	tmYml, hasTM := data[trafficManagerConfigFileName]
+	var tmYAML []byte
+	if hasTM {
+		tmYAML = []byte(tmYml)
+	}
+	tmCfg, tmErr := parseTrafficManagerConfig(tmYAML)
+	if tmErr != nil {
+		dlog.Errorf(ctx, "invalid traffic-manager config: %v", tmErr)
+		s.recordParseError(trafficManagerConfigFileName, tmErr)
+		parseErrorsTotal.WithLabelValues(TrafficManagerConfigKind.String()).Inc()
+	} else {
+		if hasTM {
+			dlog.Debugf(ctx, "Refreshed traffic-manager config: %s", tmYml)
+		} else {
+			dlog.Debugf(ctx, "Cleared traffic-manager config")
+		}
+		if !bytes.Equal(tmYAML, oldTrafficManagerYAML) {
+			s.recordReload(TrafficManagerConfigKind)
+		}
+	}
+
+	s.Lock()
+	s.clientYAML = clientYAML
+	s.trafficManagerYAML = tmYAML
+	if clientErr == nil {
+		s.clientConfig = clientCfg
+	}
+	if tmErr == nil {
+		s.trafficManagerConfig = tmCfg
+	}
+	switch {
+	case clientErr != nil:
+		s.lastErr = clientErr
+	case tmErr != nil:
+		s.lastErr = tmErr
+	default:
+		s.lastErr = nil
+	}
+	s.Unlock()
+
+	s.publish(ClientConfigKind, ConfigEvent{Kind: ClientConfigKind, Client: clientCfg, Err: clientErr})
+	s.publish(TrafficManagerConfigKind, ConfigEvent{Kind: TrafficManagerConfigKind, TrafficManager: tmCfg, Err: tmErr})
+}
+
+func (s *watcherState) recordParseError(file string, err error) {
+	if s.recorder == nil || s.eventRef == nil {
+		return
+	}
+	s.recorder.Eventf(s.eventRef(), core.EventTypeWarning, "ConfigParseFailed", "%s: %v", file, err)
+}
+
+// recordReload updates the reload metrics for kind and records a Kubernetes Event, so an
+// operator editing the ConfigMap gets the same visibility into an accepted change as
+// they already get into a rejected one via recordParseError.
+func (s *watcherState) recordReload(kind ConfigKind) {
+	reloadsTotal.WithLabelValues(kind.String()).Inc()
+	lastReloadTimestamp.WithLabelValues(kind.String()).Set(float64(time.Now().Unix()))
+	if s.recorder == nil || s.eventRef == nil {
+		return
+	}
+	s.recorder.Eventf(s.eventRef(), core.EventTypeNormal, "ConfigReloaded", "%s reloaded", kind)
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigKind identifies one of the two configs served by a Watcher.
+type ConfigKind int
+
+const (
+	ClientConfigKind ConfigKind = iota
+	TrafficManagerConfigKind
+)
+
+func (k ConfigKind) String() string {
+	switch k {
+	case ClientConfigKind:
+		return "client"
+	case TrafficManagerConfigKind:
+		return "traffic-manager"
+	default:
+		return "unknown"
+	}
+}
+
+// ConfigEvent is delivered on a Subscribe channel whenever a config is successfully
+// re-parsed, or fails to parse. On success, the field matching Kind holds the parsed
+// config, which is nil when the sources carry no file of that kind; the other field is
+// always nil. When Err is non-nil, both are nil.
+type ConfigEvent struct {
+	Kind           ConfigKind
+	Client         *ClientConfig
+	TrafficManager *TrafficManagerConfig
+	Err            error
+}
+
+// ClientConfig is the parsed form of client.yaml.
+type ClientConfig struct {
+	LogLevel string            `json:"logLevel,omitempty"`
+	Timeouts map[string]string `json:"timeouts,omitempty"`
+	Images   ImagesConfig      `json:"images,omitempty"`
+}
+
+// ImagesConfig pins the images the client should expect the cluster side to use.
+type ImagesConfig struct {
+	AgentImage   string `json:"agentImage,omitempty"`
+	WebhookImage string `json:"webhookImage,omitempty"`
+}
+
+// TrafficManagerConfig is the parsed form of traffic-manager.yaml.
+type TrafficManagerConfig struct {
+	LogLevel    string `json:"logLevel,omitempty"`
+	GracePeriod string `json:"gracePeriod,omitempty"`
+	AgentImage  string `json:"agentImage,omitempty"`
+}
+
+var validLogLevels = map[string]bool{
+	"trace": true,
+	"debug": true,
+	"info":  true,
+	"warn":  true,
+	"error": true,
+}
+
+func parseClientConfig(data []byte) (*ClientConfig, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	cc := &ClientConfig{}
+	if err := yaml.UnmarshalStrict(data, cc); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", clientConfigFileName, err)
+	}
+	if cc.LogLevel != "" && !validLogLevels[cc.LogLevel] {
+		return nil, fmt.Errorf("%s: invalid logLevel %q", clientConfigFileName, cc.LogLevel)
+	}
+	for name, d := range cc.Timeouts {
+		if _, err := time.ParseDuration(d); err != nil {
+			return nil, fmt.Errorf("%s: invalid timeout %q=%q: %w", clientConfigFileName, name, d, err)
+		}
+	}
+	return cc, nil
+}
+
+func parseTrafficManagerConfig(data []byte) (*TrafficManagerConfig, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	tc := &TrafficManagerConfig{}
+	if err := yaml.UnmarshalStrict(data, tc); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", trafficManagerConfigFileName, err)
+	}
+	if tc.LogLevel != "" && !validLogLevels[tc.LogLevel] {
+		return nil, fmt.Errorf("%s: invalid logLevel %q", trafficManagerConfigFileName, tc.LogLevel)
+	}
+	if tc.AgentImage == "" {
+		return nil, fmt.Errorf("%s: agentImage is required", trafficManagerConfigFileName)
+	}
+	if tc.GracePeriod != "" {
+		if _, err := time.ParseDuration(tc.GracePeriod); err != nil {
+			return nil, fmt.Errorf("%s: invalid gracePeriod %q: %w", trafficManagerConfigFileName, tc.GracePeriod, err)
+		}
+	}
+	return tc, nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import "testing"
+
+func TestParseClientConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "empty data returns nil config", data: "", wantErr: false},
+		{name: "valid", data: "logLevel: debug\ntimeouts:\n  agentInstall: 30s\n", wantErr: false},
+		{name: "invalid logLevel", data: "logLevel: loud\n", wantErr: true},
+		{name: "invalid timeout duration", data: "timeouts:\n  agentInstall: soon\n", wantErr: true},
+		{name: "unknown field", data: "bogus: true\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc, err := parseClientConfig([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseClientConfig(%q): expected error, got none", tt.data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseClientConfig(%q): unexpected error: %v", tt.data, err)
+			}
+			if tt.data == "" && cc != nil {
+				t.Fatalf("parseClientConfig(\"\"): expected nil config, got %+v", cc)
+			}
+		})
+	}
+}
+
+func TestParseTrafficManagerConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "empty data returns nil config", data: "", wantErr: false},
+		{name: "valid", data: "agentImage: docker.io/datawire/tel2:2.18.0\ngracePeriod: 5m\n", wantErr: false},
+		{name: "missing agentImage", data: "logLevel: info\n", wantErr: true},
+		{name: "invalid logLevel", data: "agentImage: tel2:2.18.0\nlogLevel: loud\n", wantErr: true},
+		{name: "invalid gracePeriod duration", data: "agentImage: tel2:2.18.0\ngracePeriod: forever\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := parseTrafficManagerConfig([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseTrafficManagerConfig(%q): expected error, got none", tt.data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTrafficManagerConfig(%q): unexpected error: %v", tt.data, err)
+			}
+			if tt.data == "" && tc != nil {
+				t.Fatalf("parseTrafficManagerConfig(\"\"): expected nil config, got %+v", tc)
+			}
+		})
+	}
+}